@@ -0,0 +1,100 @@
+// Command tf2-vpkmount mounts a vpk as a read-only FUSE filesystem.
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/vpkfs"
+	"github.com/spf13/pflag"
+)
+
+var (
+	VPKPrefix = pflag.StringP("vpk-prefix", "p", "english", "VPK prefix")
+	Threads   = pflag.IntP("threads", "j", runtime.NumCPU(), "The number of decompression threads to use when filling a file's chunk cache (0 to only decompress chunks as they are read) (defaults to the number of cores)")
+	// Foreground is currently a no-op: tf2-vpkmount never detaches from the
+	// terminal regardless of this flag. It's kept for command-line
+	// compatibility; the signal handler that unmounts on Ctrl+C/SIGTERM
+	// always runs, whether or not this is set.
+	Foreground = pflag.BoolP("foreground", "f", false, "Do not detach from the terminal (currently always the case)")
+	Debug      = pflag.Bool("debug", false, "Print FUSE debug logs")
+
+	Help = pflag.Bool("help", false, "Show this help message")
+)
+
+func main() {
+	pflag.Parse()
+
+	argv := pflag.Args()
+	if len(argv) < 2 || len(argv) > 3 || *Help {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] mountpoint (vpk_dir vpk_name)|vpk_path\n\noptions:\n%s", os.Args[0], pflag.CommandLine.FlagUsages())
+		if !*Help {
+			os.Exit(2)
+		}
+		return
+	}
+
+	if *Threads < 0 {
+		*Threads = 0
+	}
+
+	mountpoint := argv[0]
+
+	var (
+		err error
+		vpk tf2vpk.ValvePak
+	)
+	if len(argv) == 3 {
+		fmt.Printf("mounting vpk %q (in %q) at %q\n", argv[1], argv[2], mountpoint)
+		vpk, err = tf2vpk.VPK(argv[1], *VPKPrefix, argv[2]), nil
+	} else {
+		fmt.Printf("mounting vpk %q at %q\n", argv[1], mountpoint)
+		vpk, err = tf2vpk.VPKFromPath(argv[1], *VPKPrefix)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: resolve vpk: %v\n", err)
+		os.Exit(1)
+	}
+
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: open vpk: %v\n", err)
+		os.Exit(1)
+	}
+	defer r.Close()
+
+	root := vpkfs.NewRoot(r, *Threads)
+
+	srv, err := fs.Mount(mountpoint, root, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:     "tf2vpk",
+			Name:       "tf2-vpkmount",
+			Debug:      *Debug,
+			AllowOther: false,
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: mount %q: %v\n", mountpoint, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("mounted, press ctrl+c or unmount %q to exit\n", mountpoint)
+
+	// srv.Wait blocks regardless of --foreground (we don't actually detach
+	// from the terminal), so the signal handler below is the only way to
+	// unmount gracefully on Ctrl+C or SIGTERM; it must run unconditionally.
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		<-c
+		srv.Unmount()
+	}()
+
+	srv.Wait()
+}
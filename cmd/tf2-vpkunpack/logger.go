@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pg9182/tf2vpk/internal"
+)
+
+// logger reports tf2-vpkunpack's progress and errors. textLogger prints
+// human-readable lines ("... <step>", "[i/n] path (size)", "error: ..." and a
+// final "success"); its status lines before the file list are worded as
+// completed steps ("generated .vpkflags", "creating output") and it prints no
+// blank line before the file list. jsonLogger emits one JSON object per event
+// so scripts and GUIs wrapping the tool don't have to parse decorated text.
+type logger interface {
+	// Step reports a minor status update that isn't part of the stable
+	// event schema (e.g. "creating output").
+	Step(msg string)
+
+	// Start reports the vpk being unpacked and the output it's being
+	// unpacked to, or, if isNew is set, that a new empty vpk is being
+	// initialized at out instead (in which case vpk is ignored).
+	Start(vpk string, isNew bool, out string)
+
+	// VPKFlags reports that .vpkflags was generated and written.
+	VPKFlags(explicit bool)
+
+	// VPKIgnore reports that .vpkignore was generated and written.
+	VPKIgnore(defaultEntries bool)
+
+	// File reports the extraction, skipping (via --resume), or exclusion of a
+	// single file.
+	File(i, total int, path string, uncompressed uint64, excluded, skipped bool, bytesWritten uint64, dur time.Duration)
+
+	// Done reports that extraction finished successfully.
+	Done(excludedCount int)
+
+	// Error reports a fatal error. ctx is a short description of what was
+	// being attempted, matching the existing "error: <ctx>: <err>" text, or
+	// empty if err already stands on its own.
+	Error(ctx string, err error)
+}
+
+// newLogger returns the logger for the given --output format.
+func newLogger(format string) (logger, error) {
+	switch format {
+	case "text":
+		return textLogger{}, nil
+	case "ndjson":
+		return &jsonLogger{enc: json.NewEncoder(os.Stdout)}, nil
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return &jsonLogger{enc: enc}, nil
+	default:
+		return nil, fmt.Errorf("unknown --output %q", format)
+	}
+}
+
+// textLogger is the fmt.Printf/fmt.Fprintf-based human-readable output.
+type textLogger struct{}
+
+func (textLogger) Step(msg string) {
+	fmt.Printf("... %s\n", msg)
+}
+
+func (textLogger) Start(vpk string, isNew bool, out string) {
+	if isNew {
+		fmt.Printf("initializing new vpk in %q\n", out)
+		return
+	}
+	fmt.Printf("unpacking vpk %q to %q\n", vpk, out)
+}
+
+func (textLogger) VPKFlags(explicit bool) {
+	if explicit {
+		fmt.Printf("... generated .vpkflags (without inheritance)\n")
+	} else {
+		fmt.Printf("... generated .vpkflags\n")
+	}
+}
+
+func (textLogger) VPKIgnore(defaultEntries bool) {
+	if defaultEntries {
+		fmt.Printf("... generated .vpkignore\n")
+	} else {
+		fmt.Printf("... generated .vpkignore (without default entries)\n")
+	}
+}
+
+func (textLogger) File(i, total int, path string, uncompressed uint64, excluded, skipped bool, bytesWritten uint64, dur time.Duration) {
+	if excluded {
+		fmt.Printf("[%4d/%4d] %s (excluded)\n", i+1, total, path)
+		return
+	}
+	if skipped {
+		fmt.Printf("[%4d/%4d] %s (%s, unchanged, skipped)\n", i+1, total, path, internal.FormatBytesSI(int64(uncompressed)))
+		return
+	}
+	fmt.Printf("[%4d/%4d] %s (%s)\n", i+1, total, path, internal.FormatBytesSI(int64(uncompressed)))
+}
+
+func (textLogger) Done(excludedCount int) {
+	fmt.Println()
+	if excludedCount != 0 {
+		fmt.Printf("success (%d files excluded by command-line filter)\n", excludedCount)
+	} else {
+		fmt.Printf("success\n")
+	}
+}
+
+func (textLogger) Error(ctx string, err error) {
+	if ctx == "" {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "error: %s: %v\n", ctx, err)
+}
+
+// jsonLogger emits one JSON object per event to stdout.
+type jsonLogger struct {
+	enc *json.Encoder
+}
+
+func (l *jsonLogger) emit(v any) {
+	// encoding errors writing to stdout aren't actionable; ignore them like
+	// the text logger ignores fmt.Printf errors.
+	_ = l.enc.Encode(v)
+}
+
+func (l *jsonLogger) Step(msg string) {
+	l.emit(struct {
+		Event   string `json:"event"`
+		Message string `json:"message"`
+	}{"step", msg})
+}
+
+func (l *jsonLogger) Start(vpk string, isNew bool, out string) {
+	l.emit(struct {
+		Event string `json:"event"`
+		VPK   string `json:"vpk,omitempty"`
+		New   bool   `json:"new"`
+		Out   string `json:"out"`
+	}{"start", vpk, isNew, out})
+}
+
+func (l *jsonLogger) VPKFlags(explicit bool) {
+	l.emit(struct {
+		Event    string `json:"event"`
+		Explicit bool   `json:"explicit"`
+	}{"vpkflags", explicit})
+}
+
+func (l *jsonLogger) VPKIgnore(defaultEntries bool) {
+	l.emit(struct {
+		Event          string `json:"event"`
+		DefaultEntries bool   `json:"default_entries"`
+	}{"vpkignore", defaultEntries})
+}
+
+func (l *jsonLogger) File(i, total int, path string, uncompressed uint64, excluded, skipped bool, bytesWritten uint64, dur time.Duration) {
+	l.emit(struct {
+		Event        string `json:"event"`
+		Index        int    `json:"index"`
+		Total        int    `json:"total"`
+		Path         string `json:"path"`
+		Uncompressed uint64 `json:"uncompressed"`
+		Excluded     bool   `json:"excluded"`
+		Skipped      bool   `json:"skipped"`
+		BytesWritten uint64 `json:"bytes_written"`
+		DurationMS   int64  `json:"duration_ms"`
+	}{"file", i, total, path, uncompressed, excluded, skipped, bytesWritten, dur.Milliseconds()})
+}
+
+func (l *jsonLogger) Done(excludedCount int) {
+	l.emit(struct {
+		Event         string `json:"event"`
+		ExcludedCount int    `json:"excluded_count"`
+	}{"done", excludedCount})
+}
+
+func (l *jsonLogger) Error(ctx string, err error) {
+	l.emit(struct {
+		Event   string `json:"event"`
+		Context string `json:"context"`
+		Error   string `json:"error"`
+	}{"error", ctx, err.Error()})
+}
@@ -3,13 +3,14 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"hash/crc32"
 	"io"
-	"io/fs"
 	"os"
-	"path/filepath"
 	"runtime"
+	"sync"
+	"time"
 
 	"github.com/pg9182/tf2vpk"
 	"github.com/pg9182/tf2vpk/internal"
@@ -17,12 +18,24 @@ import (
 	"github.com/spf13/pflag"
 )
 
+// parallelReadThreshold is the uncompressed file size above which extraction
+// uses OpenFileParallel instead of a plain single-threaded read. Spinning up
+// a parallel decompressor isn't worth it for small files.
+const parallelReadThreshold = 4 << 20 // 4 MiB
+
 var (
 	VPKPrefix = pflag.StringP("vpk-prefix", "p", "english", "VPK prefix")
 
 	VPKFlagsExplicit = pflag.Bool("vpkflags-explicit", false, "Do not optimize vpkflags for inheritance; generate one line for each file")
 	VPKIgnoreEmpty   = pflag.Bool("vpkignore-no-default", false, "Do not add default vpkignore entries")
 	Threads          = pflag.IntP("threads", "j", runtime.NumCPU(), "The number of decompression threads to use while verifying checksums (0 to only decompress chunks as they are read) (defaults to the number of cores)")
+	ExtractWorkers   = pflag.IntP("extract-workers", "w", 1, "The number of files to extract in parallel (distinct from --threads, which controls per-file chunk parallelism)")
+	Format           = pflag.String("format", "dir", "Output format: dir, tar, tarzst, zip, memcheck (extract and verify without writing output)")
+	Output           = pflag.String("output", "text", "Progress output format: text, json, ndjson")
+
+	Resume     = pflag.Bool("resume", false, "Skip re-extracting files that still match the .vpkmanifest from a previous run against this output (requires --format dir)")
+	Prune      = pflag.Bool("prune", false, "With --resume, remove previously-extracted files that are no longer present in the vpk")
+	VerifyOnly = pflag.Bool("verify-only", false, "Report drift against the .vpkmanifest from a previous run without writing or removing anything (requires --format dir)")
 
 	Exclude = pflag.StringSlice("exclude", nil, "Excludes files or directories matching the provided glob (anchor to the start with /)")
 	Include = pflag.StringSlice("include", nil, "Negates --exclude for files or directories matching the provided glob")
@@ -35,7 +48,7 @@ func main() {
 
 	argv := pflag.Args()
 	if len(argv) == 0 || len(argv) > 3 || *Help {
-		fmt.Fprintf(os.Stderr, "usage: %s [options] empty_output_path [(vpk_dir vpk_name)|vpk_path]\n\noptions:\n%s", os.Args[0], pflag.CommandLine.FlagUsages())
+		fmt.Fprintf(os.Stderr, "usage: %s [options] output_path [(vpk_dir vpk_name)|vpk_path]\n\nfor --format dir, output_path must be an empty (or nonexistent) directory, unless --resume is set.\nfor --format tar/tarzst/zip, output_path is the archive to write, or \"-\" for stdout.\nfor --format memcheck, output_path is ignored.\n\noptions:\n%s", os.Args[0], pflag.CommandLine.FlagUsages())
 		if !*Help {
 			os.Exit(2)
 		}
@@ -48,52 +61,82 @@ func main() {
 	if *Threads > runtime.NumCPU() {
 		runtime.GOMAXPROCS(*Threads)
 	}
+	if *ExtractWorkers <= 0 {
+		*ExtractWorkers = runtime.NumCPU()
+	}
+
+	log, err := newLogger(*Output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
+
+	if (*Resume || *VerifyOnly) && *Format != "dir" {
+		log.Error("", fmt.Errorf("--resume and --verify-only require --format dir"))
+		os.Exit(2)
+	}
+	if *Prune && !*Resume {
+		log.Error("", fmt.Errorf("--prune requires --resume"))
+		os.Exit(2)
+	}
 
 	vpkOut := argv[0]
 
-	var (
-		err error
-		vpk tf2vpk.ValvePak
-	)
+	var vpk tf2vpk.ValvePak
 	if len(argv) == 3 {
-		fmt.Printf("unpacking vpk %q (in %q) to %q\n", argv[1], argv[2], vpkOut)
+		log.Start(fmt.Sprintf("%s (in %s)", argv[1], argv[2]), false, vpkOut)
 		vpk, err = tf2vpk.VPK(argv[1], *VPKPrefix, argv[2]), nil
 	} else if len(argv) == 2 {
-		fmt.Printf("unpacking vpk %q to %q\n", argv[1], vpkOut)
+		log.Start(argv[1], false, vpkOut)
 		vpk, err = tf2vpk.VPKFromPath(argv[1], *VPKPrefix)
 	} else {
-		fmt.Printf("initializing new vpk in %q\n", vpkOut)
+		log.Start("", true, vpkOut)
 		vpk, err = nil, nil
 	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: resolve vpk: %v\n", err)
+		log.Error("resolve vpk", err)
 		os.Exit(1)
 	}
 
 	var r *tf2vpk.Reader
 	if vpk != nil {
 		if r, err = tf2vpk.NewReader(vpk); err != nil {
-			fmt.Fprintf(os.Stderr, "error: open vpk: %v\n", err)
+			log.Error("open vpk", err)
 			os.Exit(1)
 		}
 		defer r.Close()
 	}
 
-	if *VPKFlagsExplicit && r != nil {
-		fmt.Printf("... generating .vpkflags (without inheritance)\n")
-	} else {
-		fmt.Printf("... generating .vpkflags\n")
+	if *VerifyOnly {
+		if r == nil {
+			log.Error("", fmt.Errorf("--verify-only requires an existing vpk"))
+			os.Exit(2)
+		}
+		excluded := computeExcluded(log, r.Root.File)
+		old, err := loadManifest(vpkOut)
+		if err != nil {
+			log.Error("read manifest", err)
+			os.Exit(1)
+		}
+		drifted := verifyDrift(log, r, vpkOut, excluded, old)
+		if drifted > 0 {
+			log.Step(fmt.Sprintf("%d file(s) drifted from the manifest", drifted))
+			os.Exit(1)
+		}
+		log.Step("no drift from the manifest")
+		return
 	}
+
 	var vpkFlags vpkutil.VPKFlags
 	if r != nil {
 		if *VPKFlagsExplicit {
 			if err := vpkFlags.GenerateExplicit(r.Root); err != nil {
-				fmt.Fprintf(os.Stderr, "error: generate vpkflags without inheritance: %v\n", err)
+				log.Error("generate vpkflags without inheritance", err)
 				os.Exit(1)
 			}
 		} else {
 			if err := vpkFlags.Generate(r.Root); err != nil {
-				fmt.Fprintf(os.Stderr, "error: generate vpkflags: %v\n", err)
+				log.Error("generate vpkflags", err)
 				os.Exit(1)
 			}
 		}
@@ -102,133 +145,384 @@ func main() {
 			panic(fmt.Errorf("BUG: test generated vpkflags: %w", err))
 		}
 	}
+	log.VPKFlags(*VPKFlagsExplicit)
 
-	if *VPKIgnoreEmpty {
-		fmt.Printf("... generating .vpkignore (without default entries)\n")
-	} else {
-		fmt.Printf("... generating .vpkignore\n")
-	}
 	var vpkIgnore vpkutil.VPKIgnore
 	if !*VPKIgnoreEmpty {
 		vpkIgnore.AddDefault()
 	}
 	if r != nil {
 		if err := vpkIgnore.AddAutoExclusions(r.Root); err != nil {
-			fmt.Fprintf(os.Stderr, "error: generate vpkignore: %v\n", err)
+			log.Error("generate vpkignore", err)
 			os.Exit(1)
 		}
 	}
+	log.VPKIgnore(!*VPKIgnoreEmpty)
 
-	fmt.Printf("... creating output directory\n")
-	if err := os.Mkdir(vpkOut, 0777); err != nil && !errors.Is(err, fs.ErrExist) {
-		fmt.Fprintf(os.Stderr, "error: create output directory: %v\n", err)
+	xfs, closeXFS := newExtractFS(log, *Format, vpkOut)
+	defer closeXFS()
+
+	log.Step("creating output")
+	if err := xfs.Mkdir("."); err != nil {
+		log.Error("create output", err)
 		os.Exit(1)
 	}
-	if dis, err := os.ReadDir(vpkOut); err != nil {
-		fmt.Fprintf(os.Stderr, "error: list output directory: %v\n", err)
+	if *Resume {
+		// resuming writes into a previous run's output, which is expected to
+		// be non-empty
+	} else if names, err := xfs.ReadDir("."); err != nil {
+		log.Error("list output", err)
 		os.Exit(1)
 	} else {
-		for _, di := range dis {
-			if !vpkIgnore.Match(di.Name()) {
-				fmt.Fprintf(os.Stderr, "error: output directory must not exist or be empty (other than ignored files), found %q\n", di.Name())
+		for _, name := range names {
+			if !vpkIgnore.Match(name) {
+				log.Error("check output", fmt.Errorf("output must not exist or be empty (other than ignored files), found %q", name))
 				os.Exit(1)
 			}
 		}
 	}
 
-	fmt.Printf("... saving .vpkflags\n")
-	if err := os.WriteFile(filepath.Join(vpkOut, ".vpkflags"), []byte(vpkFlags.String()), 0666); err != nil {
-		fmt.Fprintf(os.Stderr, "error: write .vpkflags: %v\n", err)
+	log.Step("saving .vpkflags")
+	if err := writeExtractFile(xfs, ".vpkflags", vpkFlags.String()); err != nil {
+		log.Error("write .vpkflags", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("... saving .vpkignore\n")
-	if err := os.WriteFile(filepath.Join(vpkOut, ".vpkignore"), []byte(vpkIgnore.String()), 0666); err != nil {
-		fmt.Fprintf(os.Stderr, "error: write .vpkignore: %v\n", err)
+	log.Step("saving .vpkignore")
+	if err := writeExtractFile(xfs, ".vpkignore", vpkIgnore.String()); err != nil {
+		log.Error("write .vpkignore", err)
 		os.Exit(1)
 	}
 
-	fmt.Println()
-
 	var excludedCount int
 	if r != nil {
-		for i, f := range r.Root.File {
-			var excluded bool
-			for _, x := range *Exclude {
-				if m, err := internal.MatchGlobParents(x, f.Path); err != nil {
-					fmt.Fprintf(os.Stderr, "error: process excludes: match %q against glob %q: %v\n", f.Path, x, err)
-					os.Exit(1)
-				} else if m {
-					excluded = true
-				}
+		excluded := computeExcluded(log, r.Root.File)
+		for _, x := range excluded {
+			if x {
+				excludedCount++
 			}
-			for _, x := range *Include {
-				if m, err := internal.MatchGlobParents(x, f.Path); err != nil {
-					fmt.Fprintf(os.Stderr, "error: process includes: match %q against glob %q: %v\n", f.Path, x, err)
-					os.Exit(1)
-				} else if m {
-					excluded = false
-				}
+		}
+
+		var resume *resumeState
+		if *Resume {
+			old, err := loadManifest(vpkOut)
+			if err != nil {
+				log.Error("read manifest", err)
+				os.Exit(1)
 			}
-			if excluded {
-				excludedCount++
-				fmt.Printf("[%4d/%4d] %s (excluded)\n", i+1, len(r.Root.File), f.Path)
-				continue
+			resume = &resumeState{vpkOut: vpkOut, index: old.Index()}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var (
+			wg      sync.WaitGroup
+			errOnce sync.Once
+			fatal   error
+		)
+
+		jobs := make(chan int)
+		go func() {
+			defer close(jobs)
+			for i := range r.Root.File {
+				select {
+				case jobs <- i:
+				case <-ctx.Done():
+					return
+				}
 			}
+		}()
+
+		results := make(chan extractResult)
+		for w := 0; w < *ExtractWorkers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				wr := r
+				if *ExtractWorkers > 1 {
+					owr, err := tf2vpk.NewReader(vpk)
+					if err != nil {
+						errOnce.Do(func() { fatal = fmt.Errorf("open vpk: %w", err); cancel() })
+						return
+					}
+					defer owr.Close()
+					wr = owr
+				}
+				for i := range jobs {
+					res := extractFile(wr, xfs, i, len(r.Root.File), r.Root.File[i], excluded[i], *Threads, resume)
+					// always deliver the result, even after cancellation: the
+					// draining loop below keeps reading from results until
+					// every worker exits, and dropping a completed result
+					// (picking the ctx.Done() case of a racing select) would
+					// silently stall the manifest and progress log for every
+					// later file, despite it having been written to disk.
+					results <- res
+					if res.err != nil {
+						errOnce.Do(func() { fatal = res.err; cancel() })
+						return
+					}
+				}
+			}()
+		}
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
 
-			var uncompressed uint64
-			for _, c := range f.Chunk {
-				uncompressed += c.UncompressedSize
+		// report results in VPK order regardless of which worker finished them,
+		// and build the manifest to write alongside the output
+		total := len(r.Root.File)
+		pending := make(map[int]extractResult)
+		next := 0
+		var newManifest vpkutil.Manifest
+		for res := range results {
+			pending[res.index] = res
+			for {
+				pr, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				if pr.err == nil {
+					log.File(pr.index, total, pr.path, pr.uncompressed, pr.excluded, pr.skipped, pr.bytesWritten, pr.dur)
+					if pr.excluded {
+						if resume != nil {
+							if entry, ok := resume.index[pr.path]; ok {
+								newManifest.File = append(newManifest.File, entry)
+							}
+						}
+					} else {
+						newManifest.File = append(newManifest.File, vpkutil.ManifestFile{
+							Path:         pr.path,
+							Uncompressed: pr.uncompressed,
+							CRC32:        pr.crc32,
+							ChunkCRC32:   pr.chunkCRC32,
+						})
+					}
+				}
+				next++
 			}
-			fmt.Printf("[%4d/%4d] %s (%s)\n", i+1, len(r.Root.File), f.Path, internal.FormatBytesSI(int64(uncompressed)))
+		}
 
-			outPath := filepath.Join(vpkOut, filepath.FromSlash(f.Path))
+		if fatal != nil {
+			log.Error("extract", fatal)
+			os.Exit(1)
+		}
 
-			if err := os.MkdirAll(filepath.Dir(outPath), 0777); err != nil {
-				fmt.Fprintf(os.Stderr, "error: create %q: %v\n", outPath, err)
-				os.Exit(1)
+		if *Prune && resume != nil {
+			current := make(map[string]bool, len(newManifest.File))
+			for _, mf := range newManifest.File {
+				current[mf.Path] = true
+			}
+			old := vpkutil.Manifest{}
+			for _, mf := range resume.index {
+				old.File = append(old.File, mf)
 			}
+			pruneStale(log, vpkOut, old, current)
+		}
 
-			tf, err := os.CreateTemp(vpkOut, ".vpk*")
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error: create temp file: %v\n", err)
+		log.Step("saving " + vpkutil.ManifestName)
+		if err := saveManifestXFS(xfs, newManifest); err != nil {
+			log.Error("write "+vpkutil.ManifestName, err)
+			os.Exit(1)
+		}
+	}
+
+	log.Done(excludedCount)
+}
+
+// computeExcluded evaluates --exclude/--include against every file in the
+// vpk, in order, exiting the process on a malformed glob.
+func computeExcluded(log logger, files []tf2vpk.File) []bool {
+	excluded := make([]bool, len(files))
+	for i, f := range files {
+		for _, x := range *Exclude {
+			if m, err := internal.MatchGlobParents(x, f.Path); err != nil {
+				log.Error(fmt.Sprintf("process excludes: match %q against glob %q", f.Path, x), err)
 				os.Exit(1)
+			} else if m {
+				excluded[i] = true
 			}
-			defer tf.Close()
-
-			fr, err := r.OpenFileParallel(f, *Threads)
-			if err != nil {
-				os.Remove(tf.Name())
-				fmt.Fprintf(os.Stderr, "error: read vpk file %q: %v\n", f.Path, err)
+		}
+		for _, x := range *Include {
+			if m, err := internal.MatchGlobParents(x, f.Path); err != nil {
+				log.Error(fmt.Sprintf("process includes: match %q against glob %q", f.Path, x), err)
 				os.Exit(1)
+			} else if m {
+				excluded[i] = false
 			}
+		}
+	}
+	return excluded
+}
 
-			if _, err := io.Copy(tf, fr); err != nil {
-				os.Remove(tf.Name())
-				fmt.Fprintf(os.Stderr, "error: extract vpk file %q: %v\n", f.Path, err)
-				os.Exit(1)
+// extractResult is the outcome of extracting (or skipping) a single file,
+// reported once it's this file's turn in VPK order.
+type extractResult struct {
+	index        int
+	path         string
+	uncompressed uint64
+	crc32        uint32
+	chunkCRC32   uint32
+	excluded     bool
+	skipped      bool
+	bytesWritten uint64
+	dur          time.Duration
+	err          error
+}
+
+// extractFile extracts the i-th of total files from r into xfs, or just
+// reports it if excluded is set. If resume is non-nil and f still matches the
+// manifest entry from a previous run (in size, vpk-side chunk checksum, and
+// the on-disk file's CRC32), extraction is skipped entirely. It is safe to
+// call concurrently for different files as long as r is not shared across
+// goroutines that may read overlapping files at the same time.
+func extractFile(r *tf2vpk.Reader, xfs vpkutil.ExtractFS, i, total int, f tf2vpk.File, excluded bool, threads int, resume *resumeState) extractResult {
+	var uncompressed uint64
+	for _, c := range f.Chunk {
+		uncompressed += c.UncompressedSize
+	}
+	chunkCRC32 := vpkChunkCRC32(f)
+
+	if excluded {
+		return extractResult{index: i, path: f.Path, uncompressed: uncompressed, excluded: true}
+	}
+
+	if resume != nil {
+		if entry, ok := resume.index[f.Path]; ok && entry.Uncompressed == uncompressed && entry.ChunkCRC32 == chunkCRC32 {
+			if size, crc, err := onDiskCRC32(resume.vpkOut, f.Path); err == nil && size == entry.Uncompressed && crc == entry.CRC32 {
+				return extractResult{
+					index:        i,
+					path:         f.Path,
+					uncompressed: uncompressed,
+					crc32:        crc,
+					chunkCRC32:   chunkCRC32,
+					skipped:      true,
+					bytesWritten: size,
+				}
 			}
+		}
+	}
+
+	start := time.Now()
 
-			if err := tf.Close(); err != nil {
-				os.Remove(tf.Name())
-				fmt.Fprintf(os.Stderr, "error: extract vpk file %q: %v\n", f.Path, err)
+	w, err := xfs.Create(f.Path, int64(uncompressed))
+	if err != nil {
+		return extractResult{index: i, path: f.Path, err: fmt.Errorf("create %q: %w", f.Path, err)}
+	}
+
+	var fr io.Reader
+	if uncompressed > parallelReadThreshold {
+		fr, err = r.OpenFileParallel(f, threads)
+	} else {
+		fr, err = r.OpenFile(f)
+	}
+	if err != nil {
+		w.Discard()
+		return extractResult{index: i, path: f.Path, err: fmt.Errorf("read vpk file %q: %w", f.Path, err)}
+	}
+
+	h := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(w, h), fr)
+	if err != nil {
+		w.Discard()
+		return extractResult{index: i, path: f.Path, err: fmt.Errorf("extract vpk file %q: %w", f.Path, err)}
+	}
+
+	if err := w.Close(); err != nil {
+		return extractResult{index: i, path: f.Path, err: fmt.Errorf("extract vpk file %q: %w", f.Path, err)}
+	}
+
+	return extractResult{
+		index:        i,
+		path:         f.Path,
+		uncompressed: uncompressed,
+		crc32:        h.Sum32(),
+		chunkCRC32:   chunkCRC32,
+		bytesWritten: uint64(n),
+		dur:          time.Since(start),
+	}
+}
+
+// newExtractFS builds the ExtractFS for the requested --format, along with a
+// cleanup function that must be called (after all extraction is done, but
+// before the process exits) to finalize and close it.
+func newExtractFS(log logger, format, outPath string) (vpkutil.ExtractFS, func()) {
+	switch format {
+	case "dir":
+		return vpkutil.NewOSExtractFS(outPath), func() {}
+	case "memcheck":
+		return vpkutil.NewDiscardExtractFS(), func() {}
+	case "tar", "tarzst", "zip":
+		w := io.Writer(os.Stdout)
+		var closeW func()
+		if outPath != "-" {
+			of, err := os.Create(outPath)
+			if err != nil {
+				log.Error("create output file", err)
 				os.Exit(1)
 			}
+			w, closeW = of, func() { of.Close() }
+		}
 
-			if err := os.Rename(tf.Name(), outPath); err != nil {
-				fmt.Fprintf(os.Stderr, "error: extract vpk file %q: rename temp file: %v\n", f.Path, err)
+		var (
+			axfs *vpkutil.ArchiveExtractFS
+			err  error
+		)
+		switch format {
+		case "tar":
+			axfs = vpkutil.NewTarExtractFS(w)
+		case "tarzst":
+			axfs, err = vpkutil.NewTarZstExtractFS(w)
+		case "zip":
+			axfs = vpkutil.NewZipExtractFS(w)
+		}
+		if err != nil {
+			log.Error(fmt.Sprintf("initialize %s output", format), err)
+			os.Exit(1)
+		}
+
+		return axfs, func() {
+			if err := axfs.Close(); err != nil {
+				log.Error(fmt.Sprintf("finalize %s output", format), err)
 				os.Exit(1)
 			}
-
-			// TODO: maybe extract files in parallel instead of using a parallel reader, might be faster for small files
+			if closeW != nil {
+				closeW()
+			}
 		}
+	default:
+		log.Error("", fmt.Errorf("unknown --format %q", format))
+		os.Exit(2)
+		panic("unreachable")
 	}
+}
 
-	fmt.Println()
+// writeExtractFile writes a small text file (such as .vpkflags or
+// .vpkignore) to xfs in one shot.
+func writeExtractFile(xfs vpkutil.ExtractFS, path, contents string) error {
+	w, err := xfs.Create(path, int64(len(contents)))
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, contents); err != nil {
+		w.Discard()
+		return err
+	}
+	return w.Close()
+}
 
-	if excludedCount != 0 {
-		fmt.Printf("success (%d files excluded by command-line filter)\n", excludedCount)
-	} else {
-		fmt.Printf("success\n")
+// saveManifestXFS writes m to xfs as the .vpkmanifest sidecar, so a later run
+// against the same output can use --resume or --verify-only.
+func saveManifestXFS(xfs vpkutil.ExtractFS, m vpkutil.Manifest) error {
+	w, err := xfs.Create(vpkutil.ManifestName, -1)
+	if err != nil {
+		return err
+	}
+	if err := vpkutil.WriteManifest(w, m); err != nil {
+		w.Discard()
+		return err
 	}
+	return w.Close()
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/vpkutil"
+)
+
+// vpkChunkCRC32 combines a file's per-chunk checksums, already present in
+// the vpk's directory metadata, into a single fingerprint of its vpk-side
+// content. Unlike onDiskCRC32, this never reads any file contents, so it's
+// cheap enough for --verify-only to recompute on every run.
+func vpkChunkCRC32(f tf2vpk.File) uint32 {
+	h := crc32.NewIEEE()
+	for _, c := range f.Chunk {
+		binary.Write(h, binary.LittleEndian, c.Checksum)
+	}
+	return h.Sum32()
+}
+
+// loadManifest reads the .vpkmanifest sidecar from a previous run of
+// tf2-vpkunpack against vpkOut. A missing manifest is treated as empty,
+// since --resume against a directory with no manifest should behave the same
+// as --resume against one that didn't write anything yet.
+func loadManifest(vpkOut string) (vpkutil.Manifest, error) {
+	f, err := os.Open(filepath.Join(vpkOut, vpkutil.ManifestName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return vpkutil.Manifest{}, nil
+		}
+		return vpkutil.Manifest{}, err
+	}
+	defer f.Close()
+	return vpkutil.ReadManifest(f)
+}
+
+// resumeState carries the previous manifest (if any) that --resume compares
+// freshly-extracted files against.
+type resumeState struct {
+	vpkOut string
+	index  map[string]vpkutil.ManifestFile
+}
+
+// onDiskCRC32 hashes the file at vpkOut/path as it currently exists on disk,
+// so --resume can tell whether it still matches the manifest without
+// re-decompressing the corresponding vpk entry.
+func onDiskCRC32(vpkOut, path string) (size uint64, crc uint32, err error) {
+	f, err := os.Open(filepath.Join(vpkOut, filepath.FromSlash(path)))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint64(n), h.Sum32(), nil
+}
+
+// pruneStale removes files under vpkOut that are recorded in a previous
+// manifest but are no longer present in the vpk (current), reporting each
+// one it removes.
+func pruneStale(log logger, vpkOut string, old vpkutil.Manifest, current map[string]bool) int {
+	var n int
+	for _, f := range old.File {
+		if current[f.Path] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(vpkOut, filepath.FromSlash(f.Path))); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Error(fmt.Sprintf("prune %q", f.Path), err)
+			continue
+		}
+		log.Step(fmt.Sprintf("pruned %s (no longer in vpk)", f.Path))
+		n++
+	}
+	return n
+}
+
+// verifyDrift compares every non-excluded file in r against the manifest
+// from a previous extraction, without writing, removing, or decompressing
+// anything. It reports a file as drifted if either the vpk's own per-chunk
+// checksums no longer match what was recorded, or the on-disk file no
+// longer matches what was written.
+func verifyDrift(log logger, r *tf2vpk.Reader, vpkOut string, excluded []bool, old vpkutil.Manifest) (drifted int) {
+	oldIdx := old.Index()
+	for i, f := range r.Root.File {
+		if excluded[i] {
+			continue
+		}
+
+		entry, ok := oldIdx[f.Path]
+		if !ok {
+			log.Step(fmt.Sprintf("drift: %s (not in manifest)", f.Path))
+			drifted++
+			continue
+		}
+
+		var uncompressed uint64
+		for _, c := range f.Chunk {
+			uncompressed += c.UncompressedSize
+		}
+
+		if uncompressed != entry.Uncompressed || vpkChunkCRC32(f) != entry.ChunkCRC32 {
+			log.Step(fmt.Sprintf("drift: %s (changed in vpk)", f.Path))
+			drifted++
+			continue
+		}
+
+		if size, crc, err := onDiskCRC32(vpkOut, f.Path); err != nil {
+			log.Step(fmt.Sprintf("drift: %s (missing or unreadable on disk: %v)", f.Path, err))
+			drifted++
+		} else if size != entry.Uncompressed || crc != entry.CRC32 {
+			log.Step(fmt.Sprintf("drift: %s (modified on disk)", f.Path))
+			drifted++
+		}
+	}
+	return drifted
+}
@@ -0,0 +1,182 @@
+package vpkfs
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/pg9182/tf2vpk"
+)
+
+// chunkCacheSize is the number of decompressed chunks kept per open file.
+// VPK chunks are typically large (multiple megabytes), so this bounds memory
+// use while still covering the common case of a reader scanning a file
+// roughly in order.
+const chunkCacheSize = 4
+
+// fileNode is a read-only inode for a single tf2vpk.File.
+type fileNode struct {
+	fs.Inode
+
+	root *Root
+	f    tf2vpk.File
+	size uint64
+
+	mu     sync.Mutex
+	cache  *list.List // of *cachedChunk, most-recently-used at the front
+	byIdx  map[int]*list.Element
+	stream *chunkStream // in-progress forward read, if any
+}
+
+type cachedChunk struct {
+	idx  int
+	data []byte
+}
+
+var (
+	_ fs.InodeEmbedder = (*fileNode)(nil)
+	_ fs.NodeGetattrer = (*fileNode)(nil)
+	_ fs.NodeOpener    = (*fileNode)(nil)
+)
+
+func newFileNode(root *Root, f tf2vpk.File, size uint64) *fileNode {
+	return &fileNode{
+		root:  root,
+		f:     f,
+		size:  size,
+		cache: list.New(),
+		byIdx: make(map[int]*list.Element),
+	}
+}
+
+func (n *fileNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFREG | 0444
+	out.Size = n.size
+	return 0
+}
+
+func (n *fileNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	return &fileHandle{n: n}, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+// fileHandle is a per-open handle onto a fileNode. All handles for the same
+// node share its chunk cache, so concurrent readers of the same file don't
+// each pay for their own decompression.
+type fileHandle struct {
+	n *fileNode
+}
+
+var _ fs.FileReader = (*fileHandle)(nil)
+
+func (h *fileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	if off < 0 || uint64(off) >= h.n.size {
+		return fuse.ReadResultData(nil), 0
+	}
+
+	n := h.n
+	idx, base := n.chunkAt(uint64(off))
+	if idx < 0 {
+		return fuse.ReadResultData(nil), syscall.EIO
+	}
+
+	data, errno := n.chunk(idx)
+	if errno != 0 {
+		return nil, errno
+	}
+
+	rel := int(uint64(off) - base)
+	if rel >= len(data) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := rel + len(dest)
+	if end > len(data) {
+		end = len(data)
+	}
+	return fuse.ReadResultData(data[rel:end]), 0
+}
+
+// chunkAt returns the index of the chunk containing the given uncompressed
+// offset and the uncompressed offset at which that chunk starts.
+func (n *fileNode) chunkAt(off uint64) (idx int, base uint64) {
+	var pos uint64
+	for i, c := range n.f.Chunk {
+		if off < pos+c.UncompressedSize {
+			return i, pos
+		}
+		pos += c.UncompressedSize
+	}
+	return -1, 0
+}
+
+// chunk returns the decompressed contents of chunk idx, filling it (and
+// caching any chunks skipped over along the way) if necessary.
+func (n *fileNode) chunk(idx int) ([]byte, syscall.Errno) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if el, ok := n.byIdx[idx]; ok {
+		n.cache.MoveToFront(el)
+		return el.Value.(*cachedChunk).data, 0
+	}
+
+	// If we already have a stream positioned at or before idx, keep reading
+	// forward from it instead of restarting decompression from the start of
+	// the file.
+	if n.stream == nil || n.stream.next > idx {
+		r, err := n.root.r.OpenFileParallel(n.f, n.root.threads)
+		if err != nil {
+			return nil, errnoFromErr(err)
+		}
+		n.stream = &chunkStream{r: r}
+	}
+
+	for n.stream.next <= idx {
+		data, err := n.stream.readChunk(n.f.Chunk[n.stream.next].UncompressedSize)
+		if err != nil {
+			// io.ReadFull only returns io.EOF here if the stream ended before
+			// this (non-empty) chunk could be filled at all, which means the
+			// vpk has less data than its own metadata claims; that's as real
+			// an error as any other read failure, not a normal end-of-file.
+			n.stream = nil
+			return nil, errnoFromErr(err)
+		}
+		n.put(n.stream.next, data)
+		n.stream.next++
+	}
+
+	el := n.byIdx[idx]
+	if el == nil {
+		return nil, syscall.EIO
+	}
+	return el.Value.(*cachedChunk).data, 0
+}
+
+func (n *fileNode) put(idx int, data []byte) {
+	el := n.cache.PushFront(&cachedChunk{idx: idx, data: data})
+	n.byIdx[idx] = el
+	for n.cache.Len() > chunkCacheSize {
+		back := n.cache.Back()
+		n.cache.Remove(back)
+		delete(n.byIdx, back.Value.(*cachedChunk).idx)
+	}
+}
+
+// chunkStream reads sequential whole chunks out of a single OpenFileParallel
+// stream so that a forward scan only decompresses each chunk once.
+type chunkStream struct {
+	r    io.Reader
+	next int
+}
+
+func (s *chunkStream) readChunk(uncompressedSize uint64) ([]byte, error) {
+	buf := make([]byte, uncompressedSize)
+	_, err := io.ReadFull(s.r, buf)
+	return buf, err
+}
@@ -0,0 +1,80 @@
+// Package vpkfs implements a read-only FUSE filesystem exposing the contents
+// of a tf2vpk.Reader, allowing VPKs to be inspected and used in-place without
+// extracting them first.
+package vpkfs
+
+import (
+	"context"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/pg9182/tf2vpk"
+)
+
+// Root is the root inode of a FUSE filesystem backed by a tf2vpk.Reader.
+//
+// The directory tree is synthesized from the VPK path prefixes of r.Root.File
+// since VPKs do not store directories explicitly.
+type Root struct {
+	fs.Inode
+
+	r       *tf2vpk.Reader
+	threads int
+}
+
+var (
+	_ fs.InodeEmbedder = (*Root)(nil)
+	_ fs.NodeOnAdder   = (*Root)(nil)
+)
+
+// NewRoot returns the root of a filesystem exposing r. threads is passed to
+// tf2vpk.Reader.OpenFileParallel when (re)filling a file's chunk cache.
+func NewRoot(r *tf2vpk.Reader, threads int) *Root {
+	return &Root{r: r, threads: threads}
+}
+
+// OnAdd builds the directory tree for the VPK the first time the root is
+// attached to the FUSE mount.
+func (root *Root) OnAdd(ctx context.Context) {
+	for _, f := range root.r.Root.File {
+		f := f
+
+		dir, base := path.Split(f.Path)
+		dir = strings.Trim(dir, "/")
+
+		p := &root.Inode
+		if dir != "" {
+			for _, comp := range strings.Split(dir, "/") {
+				if ch := p.GetChild(comp); ch != nil {
+					p = ch
+					continue
+				}
+				ch := p.NewPersistentInode(ctx, &fs.Inode{}, fs.StableAttr{
+					Mode: fuse.S_IFDIR,
+				})
+				p.AddChild(comp, ch, true)
+				p = ch
+			}
+		}
+
+		var size uint64
+		for _, c := range f.Chunk {
+			size += c.UncompressedSize
+		}
+
+		child := p.NewPersistentInode(ctx, newFileNode(root, f, size), fs.StableAttr{})
+		p.AddChild(base, child, true)
+	}
+}
+
+// errnoFromErr maps an error from the reader to an errno: 0 for nil, and EIO
+// for any other error.
+func errnoFromErr(err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+	return syscall.EIO
+}
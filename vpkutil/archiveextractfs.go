@@ -0,0 +1,271 @@
+package vpkutil
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArchiveExtractFS is an ExtractFS that streams extracted files into a tar or
+// zip archive as they are written, instead of to individual files on disk.
+//
+// Archives are write-once streams, so Rename and Remove always fail, and
+// ReadDir always reports the archive as empty.
+type ArchiveExtractFS struct {
+	mu sync.Mutex
+	w  archiveEntryWriter
+}
+
+// archiveEntryWriter is the subset of archive/tar.Writer and
+// archive/zip.Writer that ArchiveExtractFS needs.
+type archiveEntryWriter interface {
+	dir(name string) error
+
+	// file writes the header for a size-byte file (size may be -1 if
+	// unknown) and returns a writer for its body.
+	file(name string, size int64) (io.Writer, error)
+
+	// discard finalizes an entry whose body writer w (from file) was only
+	// given n of its declared size bytes before being abandoned, so the
+	// underlying stream stays well-formed for entries written afterward.
+	discard(w io.Writer, n, size int64) error
+
+	Close() error
+}
+
+// NewTarExtractFS returns an ExtractFS that writes a tar archive to w.
+func NewTarExtractFS(w io.Writer) *ArchiveExtractFS {
+	return &ArchiveExtractFS{w: &tarEntryWriter{tw: tar.NewWriter(w)}}
+}
+
+// NewTarZstExtractFS returns an ExtractFS that writes a zstd-compressed tar
+// archive to w.
+func NewTarZstExtractFS(w io.Writer) (*ArchiveExtractFS, error) {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return nil, fmt.Errorf("vpkutil: initialize zstd writer: %w", err)
+	}
+	return &ArchiveExtractFS{w: &tarEntryWriter{tw: tar.NewWriter(zw), zw: zw}}, nil
+}
+
+// NewZipExtractFS returns an ExtractFS that writes a zip archive to w.
+func NewZipExtractFS(w io.Writer) *ArchiveExtractFS {
+	return &ArchiveExtractFS{w: &zipEntryWriter{zw: zip.NewWriter(w)}}
+}
+
+func (a *ArchiveExtractFS) Mkdir(p string) error {
+	if p = path.Clean(p); p == "." {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.w.dir(p)
+}
+
+func (a *ArchiveExtractFS) Create(p string, size int64) (ExtractWriter, error) {
+	name := path.Clean(p)
+	if size < 0 {
+		// the size isn't known up front (e.g. the .vpkmanifest, which is
+		// serialized directly to the writer passed to it), so fall back to
+		// buffering it to learn its size before writing the header.
+		return &archiveBufferedExtractFile{a: a, name: name}, nil
+	}
+	return &archiveExtractFile{a: a, name: name, size: size}, nil
+}
+
+func (a *ArchiveExtractFS) Rename(oldpath, newpath string) error {
+	return fmt.Errorf("vpkutil: rename %q: not supported for archive output", oldpath)
+}
+
+func (a *ArchiveExtractFS) Remove(p string) error {
+	return fmt.Errorf("vpkutil: remove %q: not supported for archive output", p)
+}
+
+func (a *ArchiveExtractFS) ReadDir(p string) ([]string, error) {
+	return nil, nil // archive output always starts empty
+}
+
+// Close finalizes the archive, flushing any trailing metadata to the
+// underlying writer. It does not close the underlying writer itself.
+func (a *ArchiveExtractFS) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.w.Close()
+}
+
+// archiveExtractFile streams its body directly into the archive as it's
+// written, using the declared size to write the header up front. Since
+// archives are a single sequential stream, a.mu is held from the first Write
+// until Close or Discard, serializing writes (but not the decompression
+// feeding them) across concurrent extraction workers.
+type archiveExtractFile struct {
+	a    *ArchiveExtractFS
+	name string
+	size int64
+	w    io.Writer
+	n    int64
+}
+
+func (f *archiveExtractFile) Write(p []byte) (int, error) {
+	if f.w == nil {
+		f.a.mu.Lock()
+		w, err := f.a.w.file(f.name, f.size)
+		if err != nil {
+			f.a.mu.Unlock()
+			return 0, err
+		}
+		f.w = w
+	}
+	n, err := f.w.Write(p)
+	f.n += int64(n)
+	return n, err
+}
+
+func (f *archiveExtractFile) Close() error {
+	if f.w == nil {
+		// nothing was ever written; still emit the (empty) entry.
+		f.a.mu.Lock()
+		_, err := f.a.w.file(f.name, f.size)
+		f.a.mu.Unlock()
+		return err
+	}
+	defer f.a.mu.Unlock()
+	if f.n != f.size {
+		return fmt.Errorf("vpkutil: wrote %d bytes for %q, expected %d", f.n, f.name, f.size)
+	}
+	return nil
+}
+
+// Discard abandons the file. If the header was already written (because
+// Write was called at least once), archives are a single append-only
+// stream, so the entry itself can't be un-sent; instead, the entry is
+// finished off so the stream stays well-formed for whatever is written after
+// it: tar pads its body with zeros out to the declared size, and zip keeps
+// the short entry as written. Callers
+// that can still reach Discard before any Write (e.g. a vpk read failure
+// before the copy starts) get the full guarantee of no entry at all.
+func (f *archiveExtractFile) Discard() error {
+	if f.w == nil {
+		return nil
+	}
+	defer f.a.mu.Unlock()
+	return f.a.w.discard(f.w, f.n, f.size)
+}
+
+// archiveBufferedExtractFile buffers a file's contents in memory until
+// Close, for the rare case where Create wasn't given the file's size up
+// front (both tar and zip headers are otherwise written before the file's
+// data).
+type archiveBufferedExtractFile struct {
+	a    *ArchiveExtractFS
+	name string
+	buf  bytes.Buffer
+}
+
+func (f *archiveBufferedExtractFile) Write(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+func (f *archiveBufferedExtractFile) Close() error {
+	f.a.mu.Lock()
+	defer f.a.mu.Unlock()
+	w, err := f.a.w.file(f.name, int64(f.buf.Len()))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(f.buf.Bytes())
+	return err
+}
+
+// Discard drops the buffered contents without ever writing them, so the
+// file never appears in the archive.
+func (f *archiveBufferedExtractFile) Discard() error {
+	return nil
+}
+
+type tarEntryWriter struct {
+	tw *tar.Writer
+	zw io.Closer // non-nil when wrapping a zstd encoder for tarzst
+}
+
+func (w *tarEntryWriter) dir(name string) error {
+	return w.tw.WriteHeader(&tar.Header{
+		Name:     name + "/",
+		Typeflag: tar.TypeDir,
+		Mode:     0777,
+		ModTime:  time.Unix(0, 0),
+	})
+}
+
+func (w *tarEntryWriter) file(name string, size int64) (io.Writer, error) {
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0666,
+		Size:     size,
+		ModTime:  time.Unix(0, 0),
+	}); err != nil {
+		return nil, err
+	}
+	return w.tw, nil
+}
+
+// discard pads out the rest of a short-written entry with zeros, since
+// archive/tar tracks exactly how many body bytes it was promised and
+// refuses to start the next header until they're all accounted for.
+func (w *tarEntryWriter) discard(body io.Writer, n, size int64) error {
+	var zero [32 * 1024]byte
+	for remaining := size - n; remaining > 0; {
+		chunk := int64(len(zero))
+		if chunk > remaining {
+			chunk = remaining
+		}
+		written, err := body.Write(zero[:chunk])
+		if err != nil {
+			return err
+		}
+		remaining -= int64(written)
+	}
+	return nil
+}
+
+func (w *tarEntryWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	if w.zw != nil {
+		return w.zw.Close()
+	}
+	return nil
+}
+
+type zipEntryWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipEntryWriter) dir(name string) error {
+	_, err := w.zw.Create(name + "/")
+	return err
+}
+
+func (w *zipEntryWriter) file(name string, size int64) (io.Writer, error) {
+	return w.zw.Create(name)
+}
+
+// discard is a no-op: zip entries created via Create use a trailing data
+// descriptor, so whatever was actually written is already a well-formed
+// (if short) entry.
+func (w *zipEntryWriter) discard(body io.Writer, n, size int64) error {
+	return nil
+}
+
+func (w *zipEntryWriter) Close() error {
+	return w.zw.Close()
+}
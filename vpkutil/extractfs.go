@@ -0,0 +1,57 @@
+package vpkutil
+
+import "io"
+
+// ExtractFS is the output sink tf2-vpkunpack writes extracted files,
+// .vpkflags, and .vpkignore through. Implementations back extraction with
+// something other than a plain directory on disk, such as an archive writer
+// or an in-memory filesystem for tests.
+//
+// Paths are slash-separated and relative to the root of the output, as in
+// io/fs.
+type ExtractFS interface {
+	// Mkdir creates path and any missing parents, succeeding if path already
+	// exists as a directory.
+	Mkdir(path string) error
+
+	// Create opens path for writing, creating it (and any missing parent
+	// directories) if necessary and truncating it if it already exists. size
+	// is the number of bytes that will be written, used by implementations
+	// (such as archive output) that need to know it up front to stream
+	// without buffering; pass -1 if it isn't known ahead of time.
+	//
+	// Exactly one of Close or Discard must be called on the returned writer:
+	// Close flushes and commits the file at path, while Discard abandons it,
+	// so it never appears at path; the exception is archive output, where an
+	// entry stays in the archive once anything has been written to it.
+	Create(path string, size int64) (ExtractWriter, error)
+
+	// Rename moves oldpath to newpath, replacing newpath if it exists. Not
+	// all implementations support renaming after the fact; those return an
+	// error.
+	Rename(oldpath, newpath string) error
+
+	// Remove removes path. Not all implementations support removing a file
+	// after the fact; those return an error.
+	Remove(path string) error
+
+	// ReadDir lists the immediate contents of path, or returns an empty list
+	// if path does not exist or the implementation has no notion of
+	// pre-existing contents.
+	ReadDir(path string) ([]string, error)
+}
+
+// ExtractWriter is the writer returned by ExtractFS.Create.
+type ExtractWriter interface {
+	io.Writer
+
+	// Close flushes the file and commits it at its final path.
+	Close() error
+
+	// Discard abandons the file, discarding anything written so far. After
+	// Discard, the file does not exist at its final path, except in archive
+	// output if anything was already written to it. Used on error
+	// paths where a partially-written file would be worse than no file at
+	// all.
+	Discard() error
+}
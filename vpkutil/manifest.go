@@ -0,0 +1,54 @@
+package vpkutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ManifestName is the conventional filename for the manifest sidecar written
+// alongside a directory extracted by tf2-vpkunpack, recording enough state to
+// support --resume and --verify-only on a later run.
+const ManifestName = ".vpkmanifest"
+
+// Manifest records, for every file written during an extraction, enough
+// information to detect on a later run whether it needs to be re-extracted.
+type Manifest struct {
+	File []ManifestFile `json:"file"`
+}
+
+// ManifestFile is a single file's entry in a Manifest.
+type ManifestFile struct {
+	Path         string `json:"path"`
+	Uncompressed uint64 `json:"uncompressed"`
+	CRC32        uint32 `json:"crc32"`       // of the full decompressed contents
+	ChunkCRC32   uint32 `json:"chunk_crc32"` // of the vpk's own per-chunk checksums; cheap to recompute without decompressing, so it's what --verify-only checks against
+}
+
+// Index returns m.File keyed by path, for quick lookup while resuming.
+func (m Manifest) Index() map[string]ManifestFile {
+	idx := make(map[string]ManifestFile, len(m.File))
+	for _, f := range m.File {
+		idx[f.Path] = f
+	}
+	return idx
+}
+
+// ReadManifest reads a manifest previously written by WriteManifest.
+func ReadManifest(r io.Reader) (Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return Manifest{}, fmt.Errorf("vpkutil: read manifest: %w", err)
+	}
+	return m, nil
+}
+
+// WriteManifest writes m in the format ReadManifest expects.
+func WriteManifest(w io.Writer, m Manifest) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return fmt.Errorf("vpkutil: write manifest: %w", err)
+	}
+	return nil
+}
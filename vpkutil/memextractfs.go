@@ -0,0 +1,137 @@
+package vpkutil
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+)
+
+// MemExtractFS is an in-memory ExtractFS. It's primarily useful for tests.
+type MemExtractFS struct {
+	mu      sync.Mutex
+	dirs    map[string]bool
+	files   map[string][]byte
+	discard bool
+}
+
+// NewMemExtractFS returns an empty in-memory ExtractFS that retains
+// everything written to it.
+func NewMemExtractFS() *MemExtractFS {
+	return &MemExtractFS{
+		dirs:  map[string]bool{".": true},
+		files: map[string][]byte{},
+	}
+}
+
+// NewDiscardExtractFS returns an ExtractFS for the "memcheck" output format:
+// it accepts writes (so the copy and its CRC check proceed normally) but
+// discards their contents immediately instead of retaining them, so
+// verifying a VPK decompresses cleanly doesn't hold the whole thing (or even
+// a single large file from it) in memory at once.
+func NewDiscardExtractFS() *MemExtractFS {
+	fs := NewMemExtractFS()
+	fs.discard = true
+	return fs
+}
+
+// File returns the contents written to path, or false if it does not exist.
+func (m *MemExtractFS) File(p string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.files[path.Clean(p)]
+	return b, ok
+}
+
+func (m *MemExtractFS) Mkdir(p string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for p = path.Clean(p); p != "." && p != "/"; p = path.Dir(p) {
+		m.dirs[p] = true
+	}
+	return nil
+}
+
+func (m *MemExtractFS) Create(p string, size int64) (ExtractWriter, error) {
+	if m.discard {
+		return discardExtractFile{}, nil
+	}
+	return &memExtractFile{m: m, name: path.Clean(p)}, nil
+}
+
+func (m *MemExtractFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldpath, newpath = path.Clean(oldpath), path.Clean(newpath)
+	data, ok := m.files[oldpath]
+	if !ok {
+		return fmt.Errorf("vpkutil: rename %q: does not exist", oldpath)
+	}
+	delete(m.files, oldpath)
+	m.files[newpath] = data
+	return nil
+}
+
+func (m *MemExtractFS) Remove(p string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p = path.Clean(p)
+	delete(m.files, p)
+	delete(m.dirs, p)
+	return nil
+}
+
+func (m *MemExtractFS) ReadDir(p string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p = path.Clean(p)
+
+	var names []string
+	for name := range m.files {
+		if path.Dir(name) == p {
+			names = append(names, path.Base(name))
+		}
+	}
+	for name := range m.dirs {
+		if name != p && path.Dir(name) == p {
+			names = append(names, path.Base(name))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+type memExtractFile struct {
+	m    *MemExtractFS
+	name string
+	buf  bytes.Buffer
+}
+
+func (f *memExtractFile) Write(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+func (f *memExtractFile) Close() error {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	f.m.files[f.name] = f.buf.Bytes()
+	for d := path.Dir(f.name); d != "." && d != "/"; d = path.Dir(d) {
+		f.m.dirs[d] = true
+	}
+	return nil
+}
+
+// Discard drops the buffered contents without ever storing them, so the
+// file never appears in m.
+func (f *memExtractFile) Discard() error {
+	return nil
+}
+
+// discardExtractFile is the ExtractWriter returned by a discarding
+// MemExtractFS: it never buffers anything written to it.
+type discardExtractFile struct{}
+
+func (discardExtractFile) Write(p []byte) (int, error) { return len(p), nil }
+func (discardExtractFile) Close() error                { return nil }
+func (discardExtractFile) Discard() error              { return nil }
@@ -0,0 +1,100 @@
+package vpkutil
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// OSExtractFS is an ExtractFS rooted at a directory on the local filesystem.
+//
+// Create writes through a temporary file that is renamed into place on
+// Close, so a crash or error partway through a file doesn't leave a partial
+// file at its final path.
+type OSExtractFS struct {
+	root string
+}
+
+// NewOSExtractFS returns an ExtractFS rooted at root. root is created by the
+// first call to Mkdir or Create if it does not already exist.
+func NewOSExtractFS(root string) *OSExtractFS {
+	return &OSExtractFS{root: root}
+}
+
+func (o *OSExtractFS) path(p string) string {
+	return filepath.Join(o.root, filepath.FromSlash(p))
+}
+
+func (o *OSExtractFS) Mkdir(p string) error {
+	return os.MkdirAll(o.path(p), 0777)
+}
+
+func (o *OSExtractFS) Create(p string, size int64) (ExtractWriter, error) {
+	full := o.path(p)
+	if err := os.MkdirAll(filepath.Dir(full), 0777); err != nil {
+		return nil, err
+	}
+	tf, err := os.CreateTemp(filepath.Dir(full), ".vpk*")
+	if err != nil {
+		return nil, err
+	}
+	return &osExtractFile{tf: tf, final: full}, nil
+}
+
+func (o *OSExtractFS) Rename(oldpath, newpath string) error {
+	return os.Rename(o.path(oldpath), o.path(newpath))
+}
+
+func (o *OSExtractFS) Remove(p string) error {
+	return os.Remove(o.path(p))
+}
+
+func (o *OSExtractFS) ReadDir(p string) ([]string, error) {
+	dis, err := os.ReadDir(o.path(p))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names := make([]string, len(dis))
+	for i, di := range dis {
+		names[i] = di.Name()
+	}
+	return names, nil
+}
+
+// osExtractFile writes to a temporary file alongside its final path, and
+// renames it into place on Close.
+type osExtractFile struct {
+	tf    *os.File
+	final string
+}
+
+func (f *osExtractFile) Write(p []byte) (int, error) {
+	return f.tf.Write(p)
+}
+
+func (f *osExtractFile) Close() error {
+	if err := f.tf.Close(); err != nil {
+		os.Remove(f.tf.Name())
+		return err
+	}
+	if err := os.Rename(f.tf.Name(), f.final); err != nil {
+		os.Remove(f.tf.Name())
+		return err
+	}
+	return nil
+}
+
+// Discard closes and removes the temporary file without renaming it into
+// place, so a failed extraction leaves no file at all at f.final rather than
+// a partial one.
+func (f *osExtractFile) Discard() error {
+	err := f.tf.Close()
+	if rerr := os.Remove(f.tf.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}